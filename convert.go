@@ -2,30 +2,122 @@ package plist
 
 // #import <CoreFoundation/CoreFoundation.h>
 // #import <CoreGraphics/CGBase.h> // for CGFloat
+//
+// // _CFKeyedArchiverUID has no public header, but these symbols have been
+// // stable across OS releases and are used by NSKeyedArchiver/Unarchiver
+// // to number objects in the archive.
+// extern CFTypeID _CFKeyedArchiverUIDGetTypeID(void);
+// extern CFTypeRef _CFKeyedArchiverUIDCreate(CFAllocatorRef allocator, uint32_t value);
+// extern uint32_t _CFKeyedArchiverUIDGetValue(CFTypeRef uid);
 import "C"
+import "encoding"
+import "errors"
+import "fmt"
 import "math"
 import "reflect"
 import "time"
 import "unsafe"
 
+// ErrNilPointer is returned by Marshal/convertValueToCFType when asked to
+// encode a nil pointer or nil interface value; plists have no literal
+// that corresponds to null.
+var ErrNilPointer = errors.New("plist: cannot encode a nil pointer or interface value")
+
+// NumberOverflowError is returned by Marshal/convertValueToCFType when an
+// unsigned integer value is too large to fit in the SInt64 that backs
+// every CFNumber, or when a UID is too large to fit in the UInt32 that
+// backs a CFKeyedArchiverUID.
+type NumberOverflowError struct {
+	Type  reflect.Type
+	Value uint64
+}
+
+func (e *NumberOverflowError) Error() string {
+	if e.Type == reflect.TypeOf(UID(0)) {
+		return fmt.Sprintf("plist: %s value %d overflows CFKeyedArchiverUID's uint32 range", e.Type, e.Value)
+	}
+	return fmt.Sprintf("plist: %s value %d overflows CFNumber's int64 range", e.Type, e.Value)
+}
+
+// UID represents a CFKeyedArchiverUID, the reference type NSKeyedArchiver
+// plists (bookmarks, iOS backups, Xcode project state) use to number the
+// objects in the archive. CFKeyedArchiverUID itself only ever stores a
+// UInt32; UID is uint64 so it fits alongside this package's other
+// numeric conversions.
+type UID uint64
+
+var cfKeyedArchiverUIDTypeID = C.CFTypeID(C._CFKeyedArchiverUIDGetTypeID())
+
+func convertUIDToCFType(u UID) (C.CFTypeRef, error) {
+	if u > math.MaxUint32 {
+		return nil, &NumberOverflowError{reflect.TypeOf(u), uint64(u)}
+	}
+	return C._CFKeyedArchiverUIDCreate(nil, C.uint32_t(u)), nil
+}
+
+func convertCFTypeToUID(cfType C.CFTypeRef) UID {
+	return UID(C._CFKeyedArchiverUIDGetValue(cfType))
+}
+
 func convertValueToCFType(obj interface{}) (C.CFTypeRef, error) {
+	if obj == nil {
+		return nil, ErrNilPointer
+	}
+	if u, ok := obj.(UID); ok {
+		return convertUIDToCFType(u)
+	}
+	if m, ok := obj.(Marshaler); ok {
+		custom, err := m.MarshalPlist()
+		if err != nil {
+			return nil, err
+		}
+		return convertValueToCFType(custom)
+	}
+	// time.Time implements encoding.TextMarshaler too, but is left to the
+	// reflect.Struct case below so it is encoded as a CFDate
+	if tm, ok := obj.(encoding.TextMarshaler); ok && reflect.TypeOf(obj) != reflect.TypeOf(time.Time{}) {
+		text, err := tm.MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		return convertValueToCFType(string(text))
+	}
 	value := reflect.ValueOf(obj)
 	switch value.Kind() {
 	case reflect.Bool:
 		return C.CFTypeRef(convertBoolToCFBoolean(value.Bool())), nil
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		return C.CFTypeRef(convertInt64ToCFNumber(value.Int())), nil
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
 		return C.CFTypeRef(convertUInt32ToCFNumber(uint32(value.Uint()))), nil
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		// CFNumber has no unsigned type; anything that doesn't fit in the
+		// SInt64 it's stored as can't round-trip
+		u := value.Uint()
+		if u > math.MaxInt64 {
+			return nil, &NumberOverflowError{value.Type(), u}
+		}
+		return C.CFTypeRef(convertInt64ToCFNumber(int64(u))), nil
 	case reflect.Float32, reflect.Float64:
 		return C.CFTypeRef(convertFloat64ToCFNumber(value.Float())), nil
 	case reflect.String:
 		return C.CFTypeRef(convertStringToCFString(value.String())), nil
 	case reflect.Struct:
-		// only struct type we support is time.Time
+		// time.Time gets its own CFDate representation; every other
+		// struct is encoded field-by-field as a CFDictionary
 		if value.Type() == reflect.TypeOf(time.Time{}) {
 			return C.CFTypeRef(convertTimeToCFDate(obj.(time.Time))), nil
 		}
+		// value came from reflect.ValueOf(obj) and so is never
+		// addressable, even when obj started out as a *struct (the
+		// reflect.Ptr case below unwraps via .Interface() before
+		// recursing here). Copy it into an addressable Value so
+		// convertStructToCFType's fv.CanAddr() check can actually
+		// find pointer-receiver Marshaler/TextMarshaler methods on
+		// fields.
+		addressable := reflect.New(value.Type()).Elem()
+		addressable.Set(value)
+		return convertStructToCFType(addressable)
 	case reflect.Array, reflect.Slice:
 		// check for []byte first (byte is uint8)
 		if value.Type().Elem().Kind() == reflect.Uint8 {
@@ -40,6 +132,17 @@ func convertValueToCFType(obj interface{}) (C.CFTypeRef, error) {
 		}
 		dict, err := convertMapToCFDictionary(value)
 		return C.CFTypeRef(dict), err
+	case reflect.Ptr:
+		// reflect.Interface isn't handled here: obj is already a Go
+		// interface{} parameter, so reflect.ValueOf(obj) always reports
+		// the Kind of its concrete dynamic type, never reflect.Interface.
+		// Every caller that might hold a *pointer* to an interface value
+		// (struct fields, slice elements, map values) also unwraps it
+		// with .Interface() before recursing here.
+		if value.IsNil() {
+			return nil, ErrNilPointer
+		}
+		return convertValueToCFType(value.Elem().Interface())
 	}
 	return nil, &UnsupportedTypeError{value.Type()}
 }
@@ -64,13 +167,19 @@ func convertCFTypeToValue(cfType C.CFTypeRef) (interface{}, error) {
 	case C.CFDictionaryGetTypeID():
 		dict, err := convertCFDictionaryToMap(C.CFDictionaryRef(cfType))
 		return dict, err
+	case cfKeyedArchiverUIDTypeID:
+		return convertCFTypeToUID(cfType), nil
 	}
 	return nil, &UnknownCFTypeError{int(typeId)}
 }
 
 // ===== CFData =====
 func convertBytesToCFData(data []byte) C.CFDataRef {
-	return C.CFDataCreate(nil, (*C.UInt8)(&data[0]), C.CFIndex(len(data)))
+	var ptr *C.UInt8
+	if len(data) > 0 {
+		ptr = (*C.UInt8)(&data[0])
+	}
+	return C.CFDataCreate(nil, ptr, C.CFIndex(len(data)))
 }
 
 func convertCFDataToBytes(cfData C.CFDataRef) []byte {
@@ -218,9 +327,11 @@ func convertCFNumberToInterface(cfNumber C.CFNumberRef) interface{} {
 		ptr = unsafe.Pointer(&short)
 		value = reflect.ValueOf(int16(0))
 	case C.kCFNumberIntType:
+		// C.int is 32 bits; using Go's int here would let reflect.NewAt
+		// below read past the end of i on platforms where int is 64 bits
 		var i C.int
 		ptr = unsafe.Pointer(&i)
-		value = reflect.ValueOf(int(0))
+		value = reflect.ValueOf(int32(0))
 	case C.kCFNumberLongType:
 		var long C.long
 		ptr = unsafe.Pointer(&long)
@@ -255,7 +366,10 @@ func convertCFNumberToInterface(cfNumber C.CFNumberRef) interface{} {
 		value = reflect.ValueOf(float64(0))
 	}
 	C.CFNumberGetValue(cfNumber, typ, ptr)
-	return value.Interface()
+	// value only carries the destination Go type above; reflect.NewAt
+	// reads back the bytes CFNumberGetValue actually wrote into ptr,
+	// rather than returning value's original zero literal
+	return reflect.NewAt(value.Type(), ptr).Elem().Interface()
 }
 
 // ===== CFArray =====
@@ -273,7 +387,7 @@ func convertSliceToCFArray(slice reflect.Value) (C.CFArrayRef, error) {
 	}()
 	// convert the slice
 	for i := 0; i < slice.Len(); i++ {
-		cfType, err := convertValueToCFType(slice.Index(i))
+		cfType, err := convertValueToCFType(slice.Index(i).Interface())
 		if err != nil {
 			return nil, err
 		}
@@ -282,11 +396,18 @@ func convertSliceToCFArray(slice reflect.Value) (C.CFArrayRef, error) {
 
 	// create the array
 	callbacks := (*C.CFArrayCallBacks)(&C.kCFTypeArrayCallBacks)
-	return C.CFArrayCreate(nil, (*unsafe.Pointer)(&plists[0]), C.CFIndex(len(plists)), callbacks), nil
+	var ptr *unsafe.Pointer
+	if len(plists) > 0 {
+		ptr = (*unsafe.Pointer)(&plists[0])
+	}
+	return C.CFArrayCreate(nil, ptr, C.CFIndex(len(plists)), callbacks), nil
 }
 
 func convertCFArrayToSlice(cfArray C.CFArrayRef) ([]interface{}, error) {
 	count := C.CFArrayGetCount(cfArray)
+	if count == 0 {
+		return []interface{}{}, nil
+	}
 	cfTypes := make([]C.CFTypeRef, int(count))
 	cfRange := C.CFRange{0, count}
 	C.CFArrayGetValues(cfArray, cfRange, (*unsafe.Pointer)(&cfTypes[0]))
@@ -334,11 +455,19 @@ func convertMapToCFDictionary(m reflect.Value) (C.CFDictionaryRef, error) {
 	// create the dictionary
 	keyCallbacks := (*C.CFDictionaryKeyCallBacks)(&C.kCFTypeDictionaryKeyCallBacks)
 	valCallbacks := (*C.CFDictionaryValueCallBacks)(&C.kCFTypeDictionaryValueCallBacks)
-	return C.CFDictionaryCreate(nil, (*unsafe.Pointer)(&keys[0]), (*unsafe.Pointer)(&values[0]), C.CFIndex(len(mapKeys)), keyCallbacks, valCallbacks), nil
+	var keysPtr, valsPtr *unsafe.Pointer
+	if len(mapKeys) > 0 {
+		keysPtr = (*unsafe.Pointer)(&keys[0])
+		valsPtr = (*unsafe.Pointer)(&values[0])
+	}
+	return C.CFDictionaryCreate(nil, keysPtr, valsPtr, C.CFIndex(len(mapKeys)), keyCallbacks, valCallbacks), nil
 }
 
 func convertCFDictionaryToMap(cfDict C.CFDictionaryRef) (map[string]interface{}, error) {
 	count := int(C.CFDictionaryGetCount(cfDict))
+	if count == 0 {
+		return map[string]interface{}{}, nil
+	}
 	cfKeys := make([]C.CFTypeRef, count)
 	cfVals := make([]C.CFTypeRef, count)
 	C.CFDictionaryGetKeysAndValues(cfDict, (*unsafe.Pointer)(&cfKeys[0]), (*unsafe.Pointer)(&cfVals[0]))
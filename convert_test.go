@@ -0,0 +1,209 @@
+package plist
+
+// #import <CoreFoundation/CoreFoundation.h>
+// #import <CoreGraphics/CGBase.h>
+import "C"
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+// TestCFNumberDecodeWidths constructs a CFNumber of each of the 16
+// CFNumberType primitive variants directly (bypassing our own encoder,
+// which always produces SInt64/Double) and checks that
+// convertCFNumberToInterface decodes the width CoreFoundation actually
+// reports rather than silently returning a zero value. CoreFoundation
+// stores a Char as an SInt8, so that case comes back as an int8.
+func TestCFNumberDecodeWidths(t *testing.T) {
+	eight := C.SInt8(-8)
+	sixteen := C.SInt16(-16)
+	thirtyTwo := C.SInt32(-32)
+	sixtyFour := C.SInt64(-64)
+	f32 := C.Float32(1.5)
+	f64 := C.Float64(-1.5)
+	ch := C.char(-1)
+	sh := C.short(-16)
+	in := C.int(-32)
+	lo := C.long(-64)
+	ll := C.longlong(-64)
+	fl := C.float(1.5)
+	db := C.double(-1.5)
+	idx := C.CFIndex(-64)
+	nsi := C.long(-64)
+	cgf := C.CGFloat(-1.5)
+
+	cases := []struct {
+		name string
+		typ  C.CFNumberType
+		ptr  unsafe.Pointer
+		want interface{}
+	}{
+		{"SInt8", C.kCFNumberSInt8Type, unsafe.Pointer(&eight), int8(-8)},
+		{"SInt16", C.kCFNumberSInt16Type, unsafe.Pointer(&sixteen), int16(-16)},
+		{"SInt32", C.kCFNumberSInt32Type, unsafe.Pointer(&thirtyTwo), int32(-32)},
+		{"SInt64", C.kCFNumberSInt64Type, unsafe.Pointer(&sixtyFour), int64(-64)},
+		{"Float32", C.kCFNumberFloat32Type, unsafe.Pointer(&f32), float32(1.5)},
+		{"Float64", C.kCFNumberFloat64Type, unsafe.Pointer(&f64), float64(-1.5)},
+		{"Char", C.kCFNumberCharType, unsafe.Pointer(&ch), int8(-1)},
+		{"Short", C.kCFNumberShortType, unsafe.Pointer(&sh), int16(-16)},
+		{"Int", C.kCFNumberIntType, unsafe.Pointer(&in), int32(-32)},
+		{"Long", C.kCFNumberLongType, unsafe.Pointer(&lo), int64(-64)},
+		{"LongLong", C.kCFNumberLongLongType, unsafe.Pointer(&ll), int64(-64)},
+		{"Float", C.kCFNumberFloatType, unsafe.Pointer(&fl), float32(1.5)},
+		{"Double", C.kCFNumberDoubleType, unsafe.Pointer(&db), float64(-1.5)},
+		{"CFIndex", C.kCFNumberCFIndexType, unsafe.Pointer(&idx), int64(-64)},
+		{"NSInteger", C.kCFNumberNSIntegerType, unsafe.Pointer(&nsi), int64(-64)},
+		{"CGFloat", C.kCFNumberCGFloatType, unsafe.Pointer(&cgf), float64(-1.5)},
+	}
+
+	for _, c := range cases {
+		cfNumber := C.CFNumberCreate(nil, c.typ, c.ptr)
+		got := convertCFNumberToInterface(cfNumber)
+		C.CFRelease(C.CFTypeRef(cfNumber))
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %#v (%T), want %#v (%T)", c.name, got, got, c.want, c.want)
+		}
+	}
+}
+
+func TestNumberEncodeRoundTrip(t *testing.T) {
+	// our own encoder always widens integers to SInt64 and floats
+	// (float32 included) to Double
+	cases := []struct {
+		in   interface{}
+		want interface{}
+	}{
+		{int8(-12), int64(-12)},
+		{int32(-123456), int64(-123456)},
+		{uint32(123456), int64(123456)},
+		{uint(123456), int64(123456)},
+		{float32(3.25), float64(3.25)},
+		{float64(-3.25), float64(-3.25)},
+	}
+	for _, c := range cases {
+		cfType, err := convertValueToCFType(c.in)
+		if err != nil {
+			t.Errorf("convertValueToCFType(%#v): %v", c.in, err)
+			continue
+		}
+		out, err := convertCFTypeToValue(cfType)
+		C.CFRelease(cfType)
+		if err != nil {
+			t.Errorf("convertCFTypeToValue for %#v: %v", c.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(c.want, out) {
+			t.Errorf("round trip of %#v produced %#v (%T), want %#v (%T)", c.in, out, out, c.want, c.want)
+		}
+	}
+}
+
+// TestSliceRoundTrip guards against convertSliceToCFArray encoding each
+// element by passing the reflect.Value itself instead of its underlying
+// interface{} value (which silently turned every non-[]byte slice into
+// an array of empty dictionaries).
+func TestSliceRoundTrip(t *testing.T) {
+	in := []string{"a", "b", "c"}
+	cfType, err := convertValueToCFType(in)
+	if err != nil {
+		t.Fatalf("convertValueToCFType(%#v): %v", in, err)
+	}
+	defer C.CFRelease(cfType)
+
+	out, err := convertCFTypeToValue(cfType)
+	if err != nil {
+		t.Fatalf("convertCFTypeToValue: %v", err)
+	}
+	want := []interface{}{"a", "b", "c"}
+	if !reflect.DeepEqual(want, out) {
+		t.Errorf("round trip of %#v produced %#v, want %#v", in, out, want)
+	}
+}
+
+// TestUIDRoundTrip exercises the _CFKeyedArchiverUID bridging added for
+// NSKeyedArchiver support: encoding a UID and decoding it back should
+// recover the original value and convertCFTypeToValue should dispatch on
+// cfKeyedArchiverUIDTypeID rather than falling through to
+// UnknownCFTypeError.
+func TestUIDRoundTrip(t *testing.T) {
+	cases := []UID{0, 1, 42, math.MaxUint32}
+	for _, in := range cases {
+		cfType, err := convertValueToCFType(in)
+		if err != nil {
+			t.Errorf("convertValueToCFType(%#v): %v", in, err)
+			continue
+		}
+		out, err := convertCFTypeToValue(cfType)
+		C.CFRelease(cfType)
+		if err != nil {
+			t.Errorf("convertCFTypeToValue for %#v: %v", in, err)
+			continue
+		}
+		if out != in {
+			t.Errorf("round trip of %#v produced %#v (%T)", in, out, out)
+		}
+	}
+}
+
+func TestUIDOverflow(t *testing.T) {
+	_, err := convertUIDToCFType(UID(math.MaxUint32) + 1)
+	if _, ok := err.(*NumberOverflowError); !ok {
+		t.Errorf("expected *NumberOverflowError for a UID that doesn't fit in UInt32, got %#v", err)
+	}
+}
+
+// TestPointerRoundTrip exercises the reflect.Ptr case added to
+// convertValueToCFType: a non-nil pointer should encode as whatever its
+// pointee encodes as, a nil pointer should fail with ErrNilPointer
+// rather than dereferencing, and a nil map value (a boxed nil
+// interface{}) should fail the same way rather than reaching
+// UnsupportedTypeError after a nil deref.
+func TestPointerRoundTrip(t *testing.T) {
+	s := "hello"
+	cfType, err := convertValueToCFType(&s)
+	if err != nil {
+		t.Fatalf("convertValueToCFType(&s): %v", err)
+	}
+	out, err := convertCFTypeToValue(cfType)
+	C.CFRelease(cfType)
+	if err != nil {
+		t.Fatalf("convertCFTypeToValue: %v", err)
+	}
+	if out != s {
+		t.Errorf("round trip of %#v produced %#v", &s, out)
+	}
+
+	var nilStr *string
+	if _, err := convertValueToCFType(nilStr); err != ErrNilPointer {
+		t.Errorf("expected ErrNilPointer for a nil *string, got %#v", err)
+	}
+
+	m := map[string]interface{}{"a": nil}
+	if _, err := convertValueToCFType(m); err != ErrNilPointer {
+		t.Errorf("expected ErrNilPointer for a nil map value, got %#v", err)
+	}
+}
+
+func TestUint64Overflow(t *testing.T) {
+	_, err := convertValueToCFType(uint64(math.MaxInt64) + 1)
+	if _, ok := err.(*NumberOverflowError); !ok {
+		t.Errorf("expected *NumberOverflowError for an unrepresentable uint64, got %#v", err)
+	}
+
+	v := uint64(math.MaxInt64)
+	cfType, err := convertValueToCFType(v)
+	if err != nil {
+		t.Fatalf("convertValueToCFType(%d): %v", v, err)
+	}
+	out, err := convertCFTypeToValue(cfType)
+	C.CFRelease(cfType)
+	if err != nil {
+		t.Fatalf("convertCFTypeToValue: %v", err)
+	}
+	if out != int64(v) {
+		t.Errorf("expected %d, got %#v", v, out)
+	}
+}
@@ -0,0 +1,363 @@
+package plist
+
+// #import <CoreFoundation/CoreFoundation.h>
+import "C"
+
+import (
+	"encoding"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// Marshaler is implemented by types that can convert themselves into a
+// plist representation. MarshalPlist returns a value suitable for
+// passing back into Marshal (which may itself be another Marshaler,
+// and so on).
+type Marshaler interface {
+	MarshalPlist() (interface{}, error)
+}
+
+// Unmarshaler is implemented by types that can unmarshal a plist
+// representation of themselves. The argument is one of the types
+// produced by Unmarshal: string, a sized number type, bool, []byte,
+// time.Time, UID, []interface{}, or map[string]interface{}.
+type Unmarshaler interface {
+	UnmarshalPlist(interface{}) error
+}
+
+// Marshal converts v to its CFTypeRef plist representation, which the
+// caller is responsible for releasing with CFRelease.
+//
+// Marshal converts Go values using the same rules as convertValueToCFType,
+// with the following additions: if a value implements Marshaler, its
+// MarshalPlist method is used to obtain the value to encode instead.
+// Otherwise, if it implements encoding.TextMarshaler, the marshaled text
+// is encoded as a plist string. Struct values (other than time.Time)
+// are encoded as a CFDictionary, using the field name unless overridden
+// by a `plist:"name"` tag; a tag of `plist:"-"` skips the field, and
+// the `omitempty` option skips the field when it holds a zero value.
+func Marshal(v interface{}) (C.CFTypeRef, error) {
+	return convertValueToCFType(v)
+}
+
+// Unmarshal parses the plist value in cfType and stores the result in
+// the value pointed to by v, allocating maps, slices, and pointers as
+// necessary. It uses the inverse of the rules that Marshal uses.
+func Unmarshal(cfType C.CFTypeRef, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &InvalidUnmarshalError{reflect.TypeOf(v)}
+	}
+	return unmarshalCFType(cfType, rv.Elem())
+}
+
+// InvalidUnmarshalError describes an invalid argument passed to Unmarshal.
+// The argument must be a non-nil pointer.
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "plist: Unmarshal(nil)"
+	}
+	if e.Type.Kind() != reflect.Ptr {
+		return "plist: Unmarshal(non-pointer " + e.Type.String() + ")"
+	}
+	return "plist: Unmarshal(nil " + e.Type.String() + ")"
+}
+
+// UnmarshalTypeError describes a plist value that was not appropriate
+// for the Go value it was being decoded into.
+type UnmarshalTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	return "plist: cannot unmarshal into Go value of type " + e.Type.String()
+}
+
+// ===== struct field metadata =====
+
+type fieldInfo struct {
+	index     []int
+	name      string
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // map[reflect.Type][]fieldInfo
+
+func cachedFieldInfo(t reflect.Type) []fieldInfo {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.([]fieldInfo)
+	}
+	fields := make([]fieldInfo, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			// unexported field
+			continue
+		}
+		name := f.Name
+		omitEmpty := false
+		if tag, ok := f.Tag.Lookup("plist"); ok {
+			parts := strings.Split(tag, ",")
+			if parts[0] == "-" {
+				continue
+			}
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				if opt == "omitempty" {
+					omitEmpty = true
+				}
+			}
+		}
+		fields = append(fields, fieldInfo{index: f.Index, name: name, omitEmpty: omitEmpty})
+	}
+	// LoadOrStore so that racing callers all end up with the same cached slice
+	cached, _ := fieldCache.LoadOrStore(t, fields)
+	return cached.([]fieldInfo)
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// ===== struct -> CFDictionary =====
+
+func convertStructToCFType(value reflect.Value) (C.CFTypeRef, error) {
+	fields := cachedFieldInfo(value.Type())
+	keys := make([]C.CFTypeRef, 0, len(fields))
+	values := make([]C.CFTypeRef, 0, len(fields))
+	defer func() {
+		for _, cfKey := range keys {
+			C.CFRelease(cfKey)
+		}
+		for _, cfVal := range values {
+			C.CFRelease(cfVal)
+		}
+	}()
+	for _, f := range fields {
+		fv := value.FieldByIndex(f.index)
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		cfObj, err := convertFieldToCFType(fv)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, C.CFTypeRef(convertStringToCFString(f.name)))
+		values = append(values, cfObj)
+	}
+	keyCallbacks := (*C.CFDictionaryKeyCallBacks)(&C.kCFTypeDictionaryKeyCallBacks)
+	valCallbacks := (*C.CFDictionaryValueCallBacks)(&C.kCFTypeDictionaryValueCallBacks)
+	if len(keys) == 0 {
+		return C.CFTypeRef(C.CFDictionaryCreate(nil, nil, nil, 0, keyCallbacks, valCallbacks)), nil
+	}
+	return C.CFTypeRef(C.CFDictionaryCreate(nil, (*unsafe.Pointer)(&keys[0]), (*unsafe.Pointer)(&values[0]), C.CFIndex(len(keys)), keyCallbacks, valCallbacks)), nil
+}
+
+// convertFieldToCFType is like convertValueToCFType, but also detects
+// Marshaler/TextMarshaler implemented on the field's pointer type, since
+// an addressable struct field can satisfy a pointer-receiver method set
+// even though fv.Interface() cannot.
+func convertFieldToCFType(fv reflect.Value) (C.CFTypeRef, error) {
+	if fv.CanAddr() {
+		addr := fv.Addr().Interface()
+		if m, ok := addr.(Marshaler); ok {
+			custom, err := m.MarshalPlist()
+			if err != nil {
+				return nil, err
+			}
+			return convertValueToCFType(custom)
+		}
+		// *time.Time is a TextMarshaler, but time.Time is encoded as a CFDate
+		if tm, ok := addr.(encoding.TextMarshaler); ok && fv.Type() != reflect.TypeOf(time.Time{}) {
+			text, err := tm.MarshalText()
+			if err != nil {
+				return nil, err
+			}
+			return convertValueToCFType(string(text))
+		}
+	}
+	return convertValueToCFType(fv.Interface())
+}
+
+// ===== CFType -> struct =====
+
+func unmarshalCFType(cfType C.CFTypeRef, rv reflect.Value) error {
+	if rv.CanAddr() {
+		addr := rv.Addr().Interface()
+		if u, ok := addr.(Unmarshaler); ok {
+			generic, err := convertCFTypeToValue(cfType)
+			if err != nil {
+				return err
+			}
+			return u.UnmarshalPlist(generic)
+		}
+		// *time.Time is a TextUnmarshaler, but time.Time is decoded from a
+		// CFDate by the generic path below
+		if tu, ok := addr.(encoding.TextUnmarshaler); ok && rv.Type() != reflect.TypeOf(time.Time{}) {
+			if C.CFGetTypeID(cfType) != C.CFStringGetTypeID() {
+				return &UnmarshalTypeError{rv.Type()}
+			}
+			return tu.UnmarshalText([]byte(convertCFStringToString(C.CFStringRef(cfType))))
+		}
+	}
+
+	switch {
+	case rv.Kind() == reflect.Ptr:
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return unmarshalCFType(cfType, rv.Elem())
+	case rv.Kind() == reflect.Struct && rv.Type() != reflect.TypeOf(time.Time{}):
+		if C.CFGetTypeID(cfType) != C.CFDictionaryGetTypeID() {
+			return &UnmarshalTypeError{rv.Type()}
+		}
+		return unmarshalStruct(C.CFDictionaryRef(cfType), rv)
+	case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8:
+		// []byte is decoded generically below, straight from CFData
+		if C.CFGetTypeID(cfType) != C.CFArrayGetTypeID() {
+			return &UnmarshalTypeError{rv.Type()}
+		}
+		return unmarshalSlice(C.CFArrayRef(cfType), rv)
+	case rv.Kind() == reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return &UnmarshalTypeError{rv.Type()}
+		}
+		if C.CFGetTypeID(cfType) != C.CFDictionaryGetTypeID() {
+			return &UnmarshalTypeError{rv.Type()}
+		}
+		return unmarshalMap(C.CFDictionaryRef(cfType), rv)
+	}
+
+	generic, err := convertCFTypeToValue(cfType)
+	if err != nil {
+		return err
+	}
+	return assignGeneric(rv, generic)
+}
+
+func unmarshalSlice(cfArray C.CFArrayRef, rv reflect.Value) error {
+	count := int(C.CFArrayGetCount(cfArray))
+	slice := reflect.MakeSlice(rv.Type(), count, count)
+	for i := 0; i < count; i++ {
+		cfVal := C.CFTypeRef(C.CFArrayGetValueAtIndex(cfArray, C.CFIndex(i)))
+		if err := unmarshalCFType(cfVal, slice.Index(i)); err != nil {
+			return err
+		}
+	}
+	rv.Set(slice)
+	return nil
+}
+
+func unmarshalMap(cfDict C.CFDictionaryRef, rv reflect.Value) error {
+	rv.Set(reflect.MakeMap(rv.Type()))
+	count := int(C.CFDictionaryGetCount(cfDict))
+	if count == 0 {
+		return nil
+	}
+	cfKeys := make([]C.CFTypeRef, count)
+	cfVals := make([]C.CFTypeRef, count)
+	C.CFDictionaryGetKeysAndValues(cfDict, (*unsafe.Pointer)(&cfKeys[0]), (*unsafe.Pointer)(&cfVals[0]))
+
+	keyType := rv.Type().Key()
+	elemType := rv.Type().Elem()
+	for i := 0; i < count; i++ {
+		if C.CFGetTypeID(cfKeys[i]) != C.CFStringGetTypeID() {
+			return &UnexpectedKeyTypeError{int(C.CFGetTypeID(cfKeys[i]))}
+		}
+		keyVal := reflect.ValueOf(convertCFStringToString(C.CFStringRef(cfKeys[i]))).Convert(keyType)
+		elemVal := reflect.New(elemType).Elem()
+		if err := unmarshalCFType(cfVals[i], elemVal); err != nil {
+			return err
+		}
+		rv.SetMapIndex(keyVal, elemVal)
+	}
+	return nil
+}
+
+func unmarshalStruct(cfDict C.CFDictionaryRef, rv reflect.Value) error {
+	for _, f := range cachedFieldInfo(rv.Type()) {
+		cfKey := convertStringToCFString(f.name)
+		if C.CFDictionaryContainsKey(cfDict, unsafe.Pointer(cfKey)) == C.false {
+			C.CFRelease(C.CFTypeRef(cfKey))
+			continue
+		}
+		cfVal := C.CFTypeRef(C.CFDictionaryGetValue(cfDict, unsafe.Pointer(cfKey)))
+		C.CFRelease(C.CFTypeRef(cfKey))
+
+		fv := rv.FieldByIndex(f.index)
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				fv.Set(reflect.New(fv.Type().Elem()))
+			}
+			fv = fv.Elem()
+		}
+		if err := unmarshalCFType(cfVal, fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// numericKindClass groups reflect.Kind into int/uint/float families, or
+// 0 if k isn't numeric. Two kinds in the same nonzero class can widen or
+// narrow into each other the way assignGeneric allows; anything else is
+// a mismatch that should fail rather than silently convert.
+func numericKindClass(k reflect.Kind) int {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return 1
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return 2
+	case reflect.Float32, reflect.Float64:
+		return 3
+	}
+	return 0
+}
+
+// assignGeneric assigns the generic value produced by convertCFTypeToValue
+// to rv, converting between identical-kind-class numeric types as needed
+// (e.g. a decoded int64 assigned to an int32 field, or uint32 to uint64).
+// It deliberately does not fall back to reflect's general ConvertibleTo,
+// which would also permit lossy cross-kind conversions like float64->int
+// (truncating) or []byte<->string; those are reported as
+// *UnmarshalTypeError instead, matching the kind-mismatch errors
+// Unmarshal already returns for slices/maps/structs.
+func assignGeneric(rv reflect.Value, generic interface{}) error {
+	gv := reflect.ValueOf(generic)
+	if !gv.IsValid() {
+		return nil
+	}
+	if gv.Type().AssignableTo(rv.Type()) {
+		rv.Set(gv)
+		return nil
+	}
+	class := numericKindClass(gv.Kind())
+	if class != 0 && class == numericKindClass(rv.Kind()) && gv.Type().ConvertibleTo(rv.Type()) {
+		rv.Set(gv.Convert(rv.Type()))
+		return nil
+	}
+	return &UnmarshalTypeError{rv.Type()}
+}
@@ -0,0 +1,259 @@
+package plist
+
+// #import <CoreFoundation/CoreFoundation.h>
+import "C"
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type person struct {
+	Name    string            `plist:"name"`
+	Tags    []string          `plist:"tags,omitempty"`
+	Attrs   map[string]string `plist:"attrs,omitempty"`
+	private int
+	Hidden  string `plist:"-"`
+}
+
+func TestMarshalUnmarshalStruct(t *testing.T) {
+	in := person{
+		Name:  "Alice",
+		Tags:  []string{"a", "b", "c"},
+		Attrs: map[string]string{"color": "blue"},
+	}
+	cfType, err := Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	defer C.CFRelease(cfType)
+
+	var out person
+	if err := Unmarshal(cfType, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip of %#v produced %#v", in, out)
+	}
+}
+
+func TestMarshalOmitEmpty(t *testing.T) {
+	cfType, err := Marshal(person{Name: "Bob"})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	defer C.CFRelease(cfType)
+
+	m, err := convertCFTypeToValue(cfType)
+	if err != nil {
+		t.Fatalf("convertCFTypeToValue: %v", err)
+	}
+	dict := m.(map[string]interface{})
+	if _, ok := dict["tags"]; ok {
+		t.Errorf("expected empty Tags to be omitted, got %#v", dict)
+	}
+	if _, ok := dict["attrs"]; ok {
+		t.Errorf("expected empty Attrs to be omitted, got %#v", dict)
+	}
+	if _, ok := dict["private"]; ok {
+		t.Errorf("unexported field leaked into plist: %#v", dict)
+	}
+	if _, ok := dict["Hidden"]; ok {
+		t.Errorf("plist:\"-\" field leaked into plist: %#v", dict)
+	}
+}
+
+type upperText string
+
+func (u upperText) MarshalText() ([]byte, error) {
+	return []byte(string(u)), nil
+}
+
+func (u *upperText) UnmarshalText(text []byte) error {
+	*u = upperText(text)
+	return nil
+}
+
+type wrapped struct {
+	Value upperText `plist:"value"`
+}
+
+func TestTextMarshalerUnmarshaler(t *testing.T) {
+	in := wrapped{Value: "hello"}
+	cfType, err := Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	defer C.CFRelease(cfType)
+
+	var out wrapped
+	if err := Unmarshal(cfType, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if out != in {
+		t.Errorf("round trip of %#v produced %#v", in, out)
+	}
+}
+
+type customType struct {
+	N int
+}
+
+func (c customType) MarshalPlist() (interface{}, error) {
+	return map[string]interface{}{"doubled": c.N * 2}, nil
+}
+
+func (c *customType) UnmarshalPlist(v interface{}) error {
+	m := v.(map[string]interface{})
+	c.N = int(m["doubled"].(int64)) / 2
+	return nil
+}
+
+func TestMarshalerUnmarshaler(t *testing.T) {
+	in := customType{N: 21}
+	cfType, err := Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	defer C.CFRelease(cfType)
+
+	var out customType
+	if err := Unmarshal(cfType, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if out != in {
+		t.Errorf("round trip of %#v produced %#v", in, out)
+	}
+}
+
+// lowerText and ptrCustomType implement TextMarshaler/Marshaler only on
+// the pointer receiver, which is a normal Go idiom and distinct from
+// upperText/customType above (whose MarshalText/MarshalPlist use value
+// receivers). A struct field of one of these types is only addressable
+// via convertFieldToCFType's fv.Addr() path, so these catch regressions
+// there that a value-receiver field would miss.
+type lowerText string
+
+func (l *lowerText) MarshalText() ([]byte, error) {
+	return []byte(strings.ToLower(string(*l))), nil
+}
+
+func (l *lowerText) UnmarshalText(text []byte) error {
+	*l = lowerText(text)
+	return nil
+}
+
+type ptrCustomType struct {
+	N int
+}
+
+func (c *ptrCustomType) MarshalPlist() (interface{}, error) {
+	return map[string]interface{}{"tripled": c.N * 3}, nil
+}
+
+func (c *ptrCustomType) UnmarshalPlist(v interface{}) error {
+	m := v.(map[string]interface{})
+	c.N = int(m["tripled"].(int64)) / 3
+	return nil
+}
+
+type wrappedPtrReceivers struct {
+	Text   lowerText     `plist:"text"`
+	Custom ptrCustomType `plist:"custom"`
+}
+
+func TestPointerReceiverMarshalerOnField(t *testing.T) {
+	in := wrappedPtrReceivers{Text: "HELLO", Custom: ptrCustomType{N: 7}}
+	cfType, err := Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	defer C.CFRelease(cfType)
+
+	m, err := convertCFTypeToValue(cfType)
+	if err != nil {
+		t.Fatalf("convertCFTypeToValue: %v", err)
+	}
+	dict := m.(map[string]interface{})
+	if dict["text"] != "hello" {
+		t.Errorf("expected pointer-receiver MarshalText to run, got %#v", dict["text"])
+	}
+	custom, ok := dict["custom"].(map[string]interface{})
+	if !ok || custom["tripled"] != int64(21) {
+		t.Errorf("expected pointer-receiver MarshalPlist to run, got %#v", dict["custom"])
+	}
+
+	// lowerText's MarshalText lowercases, so Text comes back as "hello"
+	want := wrappedPtrReceivers{Text: "hello", Custom: ptrCustomType{N: 7}}
+	var out wrappedPtrReceivers
+	if err := Unmarshal(cfType, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if out != want {
+		t.Errorf("round trip of %#v produced %#v, want %#v", in, out, want)
+	}
+}
+
+// TestUnmarshalRejectsCrossKindConversion guards assignGeneric's
+// contract: it should only widen/narrow within the same numeric kind
+// class (int<->int, uint<->uint, float<->float), not fall back to
+// reflect's general ConvertibleTo, which would silently truncate a
+// decoded float into an int field or convert []byte<->string.
+func TestUnmarshalRejectsCrossKindConversion(t *testing.T) {
+	cfType, err := Marshal(3.9)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	defer C.CFRelease(cfType)
+
+	var i int
+	if err := Unmarshal(cfType, &i); err == nil {
+		t.Errorf("expected error unmarshaling a float into an int, got i=%d", i)
+	} else if _, ok := err.(*UnmarshalTypeError); !ok {
+		t.Errorf("expected *UnmarshalTypeError, got %#v", err)
+	}
+
+	cfType2, err := Marshal("hello")
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	defer C.CFRelease(cfType2)
+
+	var b []byte
+	if err := Unmarshal(cfType2, &b); err == nil {
+		t.Errorf("expected error unmarshaling a string into []byte, got b=%#v", b)
+	} else if _, ok := err.(*UnmarshalTypeError); !ok {
+		t.Errorf("expected *UnmarshalTypeError, got %#v", err)
+	}
+}
+
+func TestUnmarshalTopLevelSliceAndMap(t *testing.T) {
+	cfType, err := Marshal([]string{"x", "y", "z"})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	defer C.CFRelease(cfType)
+
+	var slice []string
+	if err := Unmarshal(cfType, &slice); err != nil {
+		t.Fatalf("Unmarshal into []string: %v", err)
+	}
+	if !reflect.DeepEqual(slice, []string{"x", "y", "z"}) {
+		t.Errorf("got %#v", slice)
+	}
+
+	cfType2, err := Marshal(map[string]int{"a": 1, "b": 2})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	defer C.CFRelease(cfType2)
+
+	var m map[string]int
+	if err := Unmarshal(cfType2, &m); err != nil {
+		t.Fatalf("Unmarshal into map[string]int: %v", err)
+	}
+	if !reflect.DeepEqual(m, map[string]int{"a": 1, "b": 2}) {
+		t.Errorf("got %#v", m)
+	}
+}
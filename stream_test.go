@@ -0,0 +1,47 @@
+package plist
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type streamRecord struct {
+	Name string   `plist:"name"`
+	Tags []string `plist:"tags"`
+}
+
+func TestEncoderDecoderRoundTrip(t *testing.T) {
+	formats := []Format{FormatXML, FormatBinary}
+	for _, format := range formats {
+		in := streamRecord{Name: "Alice", Tags: []string{"a", "b"}}
+
+		var buf bytes.Buffer
+		if err := NewEncoder(&buf, format).Encode(in); err != nil {
+			t.Errorf("format %d: Encode: %v", format, err)
+			continue
+		}
+
+		dec := NewDecoder(&buf)
+		var out streamRecord
+		if err := dec.Decode(&out); err != nil {
+			t.Errorf("format %d: Decode: %v", format, err)
+			continue
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Errorf("format %d: round trip of %#v produced %#v", format, in, out)
+		}
+		if dec.Format() != format {
+			t.Errorf("format %d: Decoder.Format() reported %d", format, dec.Format())
+		}
+	}
+}
+
+func TestDecoderEmptyReaderReturnsEOF(t *testing.T) {
+	dec := NewDecoder(&bytes.Buffer{})
+	var out streamRecord
+	if err := dec.Decode(&out); err != io.EOF {
+		t.Errorf("expected io.EOF for an empty reader, got %#v", err)
+	}
+}